@@ -0,0 +1,145 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+)
+
+// QueueInfo models the subset of the /api/queues response we care about.
+type QueueInfo struct {
+	Name                   string  `json:"name"`
+	Vhost                  string  `json:"vhost"`
+	MessagesReady          float64 `json:"messages_ready"`
+	MessagesUnacknowledged float64 `json:"messages_unacknowledged"`
+	Consumers              float64 `json:"consumers"`
+}
+
+// ExchangeInfo models the subset of the /api/exchanges response we care about.
+type ExchangeInfo struct {
+	Name         string `json:"name"`
+	Vhost        string `json:"vhost"`
+	MessageStats struct {
+		PublishIn float64 `json:"publish_in"`
+	} `json:"message_stats"`
+}
+
+// NodeInfo models the subset of the /api/nodes response we care about.
+type NodeInfo struct {
+	Name          string   `json:"name"`
+	Running       bool     `json:"running"`
+	DiskFree      float64  `json:"disk_free"`
+	DiskFreeAlarm bool     `json:"disk_free_alarm"`
+	MemUsed       float64  `json:"mem_used"`
+	MemLimit      float64  `json:"mem_limit"`
+	FdUsed        float64  `json:"fd_used"`
+	SocketsUsed   float64  `json:"sockets_used"`
+	ProcUsed      float64  `json:"proc_used"`
+	Partitions    []string `json:"partitions"`
+}
+
+// VhostInfo models the subset of the /api/vhosts response we care about.
+type VhostInfo struct {
+	Name string `json:"name"`
+}
+
+// getJSON issues an authenticated GET request against a management API path
+// on the node, using its cached client and a context bound to its timeout,
+// and decodes the JSON response into v. Non-2xx responses and decode
+// failures are both reported as errors so the caller can mark the scrape
+// failed instead of emitting zero values.
+func (n *Node) getJSON(path string, v interface{}) error {
+	ctx, cancel := context.WithTimeout(context.Background(), n.timeout)
+	defer cancel()
+
+	req, err := http.NewRequestWithContext(ctx, "GET", n.Url+path, nil)
+	if err != nil {
+		return err
+	}
+	req.SetBasicAuth(n.Uname, n.Password)
+
+	resp, err := n.client.Do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode == http.StatusNotFound {
+		return errNotFound
+	}
+	if resp.StatusCode < 200 || resp.StatusCode >= 300 {
+		return fmt.Errorf("%s%s: unexpected status %s", n.Url, path, resp.Status)
+	}
+	return json.NewDecoder(resp.Body).Decode(v)
+}
+
+// errNotFound is returned by getJSON on a 404, which the management API uses
+// for endpoints backed by a plugin that isn't enabled on the broker (e.g.
+// federation or shovel). The federation, shovel and policy getters treat it
+// as "nothing to report" rather than a scrape failure; the queue, exchange,
+// node and vhost getters return it like any other error.
+var errNotFound = errors.New("management API endpoint not found")
+
+func (n *Node) getOverview() (map[string]float64, string, error) {
+	var output map[string]interface{}
+	if err := n.getJSON("/api/overview", &output); err != nil {
+		return nil, "", err
+	}
+	return unpackMetrics(output)
+}
+
+func unpackMetrics(output map[string]interface{}) (map[string]float64, string, error) {
+	metrics := make(map[string]float64)
+	mergeFloatFields(metrics, output["object_totals"])
+	mergeFloatFields(metrics, output["queue_totals"])
+	// message_stats is absent entirely on a broker that hasn't published or
+	// consumed anything yet, so its absence is not an error.
+	mergeFloatFields(metrics, output["message_stats"])
+
+	nodename, ok := output["node"].(string)
+	if !ok {
+		return metrics, "", fmt.Errorf("overview response is missing a node name")
+	}
+	return metrics, nodename, nil
+}
+
+// mergeFloatFields copies the float64-valued entries of a decoded JSON
+// object into metrics, doing nothing if field isn't a map (e.g. because the
+// broker omitted that section of the overview response).
+func mergeFloatFields(metrics map[string]float64, field interface{}) {
+	obj, ok := field.(map[string]interface{})
+	if !ok {
+		return
+	}
+	for k, v := range obj {
+		if f, ok := v.(float64); ok {
+			metrics[k] = f
+		}
+	}
+}
+
+func (n *Node) getQueues() ([]QueueInfo, error) {
+	var queues []QueueInfo
+	err := n.getJSON("/api/queues", &queues)
+	return queues, err
+}
+
+func (n *Node) getExchanges() ([]ExchangeInfo, error) {
+	var exchanges []ExchangeInfo
+	err := n.getJSON("/api/exchanges", &exchanges)
+	return exchanges, err
+}
+
+func (n *Node) getNodes() ([]NodeInfo, error) {
+	var nodes []NodeInfo
+	err := n.getJSON("/api/nodes", &nodes)
+	return nodes, err
+}
+
+func (n *Node) getVhosts() ([]VhostInfo, error) {
+	var vhosts []VhostInfo
+	err := n.getJSON("/api/vhosts", &vhosts)
+	return vhosts, err
+}
@@ -0,0 +1,39 @@
+package main
+
+import "testing"
+
+func TestUnpackMetricsMissingMessageStats(t *testing.T) {
+	output := map[string]interface{}{
+		"node": "rabbit@node1",
+		"object_totals": map[string]interface{}{
+			"queues": 3.0,
+		},
+		"queue_totals": map[string]interface{}{
+			"messages": 0.0,
+		},
+		// message_stats intentionally omitted, as a broker with no
+		// publish/consume activity yet does not include it.
+	}
+
+	metrics, nodename, err := unpackMetrics(output)
+	if err != nil {
+		t.Fatalf("unpackMetrics returned an error for a valid payload: %v", err)
+	}
+	if nodename != "rabbit@node1" {
+		t.Errorf("nodename = %q, want %q", nodename, "rabbit@node1")
+	}
+	if got, want := metrics["queues"], 3.0; got != want {
+		t.Errorf("metrics[queues] = %v, want %v", got, want)
+	}
+}
+
+func TestUnpackMetricsMissingNode(t *testing.T) {
+	output := map[string]interface{}{
+		"object_totals": map[string]interface{}{},
+		"queue_totals":  map[string]interface{}{},
+	}
+
+	if _, _, err := unpackMetrics(output); err == nil {
+		t.Fatal("expected an error when the overview response has no node name")
+	}
+}
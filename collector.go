@@ -0,0 +1,404 @@
+package main
+
+import (
+	"sync"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus"
+)
+
+// RabbitExporter is a prometheus.Collector that scrapes every configured
+// node on demand each time /metrics is polled, rather than keeping a
+// background poll loop writing into package-level GaugeVecs. This avoids
+// serving stale values when a node stops responding.
+type RabbitExporter struct {
+	nodes []Node
+
+	scrapeDuration *prometheus.Desc
+	scrapeSuccess  *prometheus.Desc
+
+	connectionsTotal       *prometheus.Desc
+	channelsTotal          *prometheus.Desc
+	queuesTotal            *prometheus.Desc
+	consumersTotal         *prometheus.Desc
+	exchangesTotal         *prometheus.Desc
+	messagesPublished      *prometheus.Desc
+	messagesUnacknowledged *prometheus.Desc
+	queueMessages          *prometheus.Desc
+
+	queueMessagesReady          *prometheus.Desc
+	queueMessagesUnacknowledged *prometheus.Desc
+	queueConsumers              *prometheus.Desc
+
+	exchangeMessagesPublishedTotal *prometheus.Desc
+
+	nodeDiskFreeBytes *prometheus.Desc
+	nodeMemUsedBytes  *prometheus.Desc
+
+	vhostUp *prometheus.Desc
+
+	nodeRunning             *prometheus.Desc
+	nodeMemLimitBytes       *prometheus.Desc
+	nodeDiskFreeAlarm       *prometheus.Desc
+	nodeFdUsed              *prometheus.Desc
+	nodeSocketsUsed         *prometheus.Desc
+	nodeErlangProcessesUsed *prometheus.Desc
+	nodePartitions          *prometheus.Desc
+
+	federationLinkState *prometheus.Desc
+	shovelState         *prometheus.Desc
+	policyApplied       *prometheus.Desc
+}
+
+// NewRabbitExporter builds a RabbitExporter from the nodes in config,
+// compiling each node's queue/exchange include/exclude filters and building
+// its cached HTTP client up front.
+func NewRabbitExporter(config *Config) (*RabbitExporter, error) {
+	nodes := *config.Nodes
+	for i := range nodes {
+		if err := nodes[i].prepare(); err != nil {
+			return nil, err
+		}
+	}
+
+	nodeLabel := []string{"node"}
+
+	return &RabbitExporter{
+		nodes: nodes,
+
+		scrapeDuration: prometheus.NewDesc(
+			prometheus.BuildFQName(namespace, "", "scrape_duration_seconds"),
+			"Time taken to scrape a node.",
+			nodeLabel, nil,
+		),
+		scrapeSuccess: prometheus.NewDesc(
+			prometheus.BuildFQName(namespace, "", "scrape_success"),
+			"Whether the scrape of a node succeeded (1 for success, 0 for failure).",
+			nodeLabel, nil,
+		),
+
+		connectionsTotal: prometheus.NewDesc(
+			prometheus.BuildFQName(namespace, "", "connections_total"),
+			"Total number of open connections.",
+			nodeLabel, nil,
+		),
+		channelsTotal: prometheus.NewDesc(
+			prometheus.BuildFQName(namespace, "", "channels_total"),
+			"Total number of open channels.",
+			nodeLabel, nil,
+		),
+		queuesTotal: prometheus.NewDesc(
+			prometheus.BuildFQName(namespace, "", "queues_total"),
+			"Total number of queues in use.",
+			nodeLabel, nil,
+		),
+		consumersTotal: prometheus.NewDesc(
+			prometheus.BuildFQName(namespace, "", "consumers_total"),
+			"Total number of message consumers.",
+			nodeLabel, nil,
+		),
+		exchangesTotal: prometheus.NewDesc(
+			prometheus.BuildFQName(namespace, "", "exchanges_total"),
+			"Total number of exchanges in use.",
+			nodeLabel, nil,
+		),
+		messagesPublished: prometheus.NewDesc(
+			prometheus.BuildFQName(namespace, "", "messages_published"),
+			"Total number of messages published.",
+			nodeLabel, nil,
+		),
+		messagesUnacknowledged: prometheus.NewDesc(
+			prometheus.BuildFQName(namespace, "", "messages_unacknowledged"),
+			"Total number of messages unacknowledged in all queues.",
+			nodeLabel, nil,
+		),
+		queueMessages: prometheus.NewDesc(
+			prometheus.BuildFQName(namespace, "", "messages"),
+			"Total number of messages in all queues.",
+			nodeLabel, nil,
+		),
+
+		queueMessagesReady: prometheus.NewDesc(
+			prometheus.BuildFQName(namespace, "", "queue_messages_ready"),
+			"Number of messages ready to be delivered to consumers.",
+			[]string{"node", "vhost", "queue"}, nil,
+		),
+		queueMessagesUnacknowledged: prometheus.NewDesc(
+			prometheus.BuildFQName(namespace, "", "queue_messages_unacknowledged"),
+			"Number of messages delivered to consumers but not yet acknowledged.",
+			[]string{"node", "vhost", "queue"}, nil,
+		),
+		queueConsumers: prometheus.NewDesc(
+			prometheus.BuildFQName(namespace, "", "queue_consumers"),
+			"Number of consumers subscribed to a queue.",
+			[]string{"node", "vhost", "queue"}, nil,
+		),
+
+		exchangeMessagesPublishedTotal: prometheus.NewDesc(
+			prometheus.BuildFQName(namespace, "", "exchange_messages_published_total"),
+			"Total number of messages published to an exchange.",
+			[]string{"node", "vhost", "exchange"}, nil,
+		),
+
+		nodeDiskFreeBytes: prometheus.NewDesc(
+			prometheus.BuildFQName(namespace, "", "node_disk_free_bytes"),
+			"Free disk space available to a node, in bytes.",
+			nodeLabel, nil,
+		),
+		nodeMemUsedBytes: prometheus.NewDesc(
+			prometheus.BuildFQName(namespace, "", "node_mem_used_bytes"),
+			"Memory used by a node, in bytes.",
+			nodeLabel, nil,
+		),
+
+		vhostUp: prometheus.NewDesc(
+			prometheus.BuildFQName(namespace, "", "vhost_up"),
+			"Whether a vhost is reported by the management API (1) or not (0).",
+			[]string{"node", "vhost"}, nil,
+		),
+
+		nodeRunning: prometheus.NewDesc(
+			prometheus.BuildFQName(namespace, "", "node_running"),
+			"Whether a cluster member is currently running (1) or stopped (0).",
+			nodeLabel, nil,
+		),
+		nodeMemLimitBytes: prometheus.NewDesc(
+			prometheus.BuildFQName(namespace, "", "node_mem_limit_bytes"),
+			"Memory high watermark for a node, in bytes.",
+			nodeLabel, nil,
+		),
+		nodeDiskFreeAlarm: prometheus.NewDesc(
+			prometheus.BuildFQName(namespace, "", "node_disk_free_alarm"),
+			"Whether a node's free disk space alarm is in effect (1) or not (0).",
+			nodeLabel, nil,
+		),
+		nodeFdUsed: prometheus.NewDesc(
+			prometheus.BuildFQName(namespace, "", "node_fd_used"),
+			"Number of file descriptors used by a node.",
+			nodeLabel, nil,
+		),
+		nodeSocketsUsed: prometheus.NewDesc(
+			prometheus.BuildFQName(namespace, "", "node_sockets_used"),
+			"Number of network sockets used by a node.",
+			nodeLabel, nil,
+		),
+		nodeErlangProcessesUsed: prometheus.NewDesc(
+			prometheus.BuildFQName(namespace, "", "node_erlang_processes_used"),
+			"Number of Erlang processes used by a node.",
+			nodeLabel, nil,
+		),
+		nodePartitions: prometheus.NewDesc(
+			prometheus.BuildFQName(namespace, "", "node_partitions"),
+			"Number of network partitions a node currently sees itself in.",
+			nodeLabel, nil,
+		),
+
+		federationLinkState: prometheus.NewDesc(
+			prometheus.BuildFQName(namespace, "", "federation_link_state"),
+			"Whether a federation link is running (1) or not (0); state holds its last reported status.",
+			[]string{"vhost", "upstream", "queue", "state"}, nil,
+		),
+		shovelState: prometheus.NewDesc(
+			prometheus.BuildFQName(namespace, "", "shovel_state"),
+			"Whether a shovel is running (1) or not (0); state holds its last reported status.",
+			[]string{"vhost", "name", "state"}, nil,
+		),
+		policyApplied: prometheus.NewDesc(
+			prometheus.BuildFQName(namespace, "", "policy_applied"),
+			"Current number of policies applied in a vhost.",
+			[]string{"vhost"}, nil,
+		),
+	}, nil
+}
+
+// Describe implements prometheus.Collector.
+func (e *RabbitExporter) Describe(ch chan<- *prometheus.Desc) {
+	ch <- e.scrapeDuration
+	ch <- e.scrapeSuccess
+	ch <- e.connectionsTotal
+	ch <- e.channelsTotal
+	ch <- e.queuesTotal
+	ch <- e.consumersTotal
+	ch <- e.exchangesTotal
+	ch <- e.messagesPublished
+	ch <- e.messagesUnacknowledged
+	ch <- e.queueMessages
+	ch <- e.queueMessagesReady
+	ch <- e.queueMessagesUnacknowledged
+	ch <- e.queueConsumers
+	ch <- e.exchangeMessagesPublishedTotal
+	ch <- e.nodeDiskFreeBytes
+	ch <- e.nodeMemUsedBytes
+	ch <- e.vhostUp
+	ch <- e.nodeRunning
+	ch <- e.nodeMemLimitBytes
+	ch <- e.nodeDiskFreeAlarm
+	ch <- e.nodeFdUsed
+	ch <- e.nodeSocketsUsed
+	ch <- e.nodeErlangProcessesUsed
+	ch <- e.nodePartitions
+	ch <- e.federationLinkState
+	ch <- e.shovelState
+	ch <- e.policyApplied
+}
+
+// Collect implements prometheus.Collector. It fans out one scrape per
+// configured node concurrently, so a single slow or unreachable node
+// doesn't hold up the others.
+func (e *RabbitExporter) Collect(ch chan<- prometheus.Metric) {
+	var wg sync.WaitGroup
+	wg.Add(len(e.nodes))
+	for _, node := range e.nodes {
+		go func(node Node) {
+			defer wg.Done()
+			e.collectNode(node, ch)
+		}(node)
+	}
+	wg.Wait()
+}
+
+func (e *RabbitExporter) collectNode(node Node, ch chan<- prometheus.Metric) {
+	start := time.Now()
+	success := e.scrapeNode(node, ch)
+
+	ch <- prometheus.MustNewConstMetric(e.scrapeDuration, prometheus.GaugeValue, time.Since(start).Seconds(), node.Name)
+	if success {
+		ch <- prometheus.MustNewConstMetric(e.scrapeSuccess, prometheus.GaugeValue, 1, node.Name)
+	} else {
+		ch <- prometheus.MustNewConstMetric(e.scrapeSuccess, prometheus.GaugeValue, 0, node.Name)
+	}
+}
+
+// scrapeNode pulls the overview, queue, exchange and node stats for a
+// single configured node and reports whether every call succeeded. A
+// failed call is logged and skipped rather than emitting zero values.
+//
+// The overview, queue, exchange and vhost metrics below use nodename, the
+// Erlang node name reported by /api/overview, for their "node" label,
+// falling back to the configured node.Name only when the overview call
+// itself fails. Mixing the two would make the same label mean different
+// things across those metric families, breaking any PromQL join on "node".
+// The /api/nodes metrics are labelled with each cluster member's own name,
+// and the federation, shovel and policy metrics carry no "node" label.
+func (e *RabbitExporter) scrapeNode(node Node, ch chan<- prometheus.Metric) bool {
+	success := true
+
+	metrics, nodename, err := node.getOverview()
+	if err != nil {
+		log.Errorf("scraping overview for node %s: %v", node.Name, err)
+		success = false
+		nodename = node.Name
+	} else {
+		ch <- prometheus.MustNewConstMetric(e.channelsTotal, prometheus.GaugeValue, metrics["channels"], nodename)
+		ch <- prometheus.MustNewConstMetric(e.connectionsTotal, prometheus.GaugeValue, metrics["connections"], nodename)
+		ch <- prometheus.MustNewConstMetric(e.consumersTotal, prometheus.GaugeValue, metrics["consumers"], nodename)
+		ch <- prometheus.MustNewConstMetric(e.queuesTotal, prometheus.GaugeValue, metrics["queues"], nodename)
+		ch <- prometheus.MustNewConstMetric(e.exchangesTotal, prometheus.GaugeValue, metrics["exchanges"], nodename)
+		ch <- prometheus.MustNewConstMetric(e.messagesPublished, prometheus.CounterValue, metrics["publish"], nodename)
+		ch <- prometheus.MustNewConstMetric(e.queueMessages, prometheus.GaugeValue, metrics["messages"], nodename)
+		ch <- prometheus.MustNewConstMetric(e.messagesUnacknowledged, prometheus.GaugeValue, metrics["messages_unacknowledged"], nodename)
+	}
+
+	queues, err := node.getQueues()
+	if err != nil {
+		log.Errorf("scraping queues for node %s: %v", node.Name, err)
+		success = false
+	}
+	for _, queue := range queues {
+		if !node.queueAllowed(queue.Name) {
+			continue
+		}
+		ch <- prometheus.MustNewConstMetric(e.queueMessagesReady, prometheus.GaugeValue, queue.MessagesReady, nodename, queue.Vhost, queue.Name)
+		ch <- prometheus.MustNewConstMetric(e.queueMessagesUnacknowledged, prometheus.GaugeValue, queue.MessagesUnacknowledged, nodename, queue.Vhost, queue.Name)
+		ch <- prometheus.MustNewConstMetric(e.queueConsumers, prometheus.GaugeValue, queue.Consumers, nodename, queue.Vhost, queue.Name)
+	}
+
+	exchanges, err := node.getExchanges()
+	if err != nil {
+		log.Errorf("scraping exchanges for node %s: %v", node.Name, err)
+		success = false
+	}
+	for _, exchange := range exchanges {
+		if !node.exchangeAllowed(exchange.Name) {
+			continue
+		}
+		ch <- prometheus.MustNewConstMetric(e.exchangeMessagesPublishedTotal, prometheus.CounterValue, exchange.MessageStats.PublishIn, nodename, exchange.Vhost, exchange.Name)
+	}
+
+	vhosts, err := node.getVhosts()
+	if err != nil {
+		log.Errorf("scraping vhosts for node %s: %v", node.Name, err)
+		success = false
+	}
+	for _, vhost := range vhosts {
+		ch <- prometheus.MustNewConstMetric(e.vhostUp, prometheus.GaugeValue, 1, nodename, vhost.Name)
+	}
+
+	nodeInfos, err := node.getNodes()
+	if err != nil {
+		log.Errorf("scraping nodes for node %s: %v", node.Name, err)
+		success = false
+	}
+	for _, n := range nodeInfos {
+		ch <- prometheus.MustNewConstMetric(e.nodeDiskFreeBytes, prometheus.GaugeValue, n.DiskFree, n.Name)
+		ch <- prometheus.MustNewConstMetric(e.nodeMemUsedBytes, prometheus.GaugeValue, n.MemUsed, n.Name)
+
+		ch <- prometheus.MustNewConstMetric(e.nodeRunning, prometheus.GaugeValue, boolValue(n.Running), n.Name)
+		ch <- prometheus.MustNewConstMetric(e.nodeMemLimitBytes, prometheus.GaugeValue, n.MemLimit, n.Name)
+		ch <- prometheus.MustNewConstMetric(e.nodeDiskFreeAlarm, prometheus.GaugeValue, boolValue(n.DiskFreeAlarm), n.Name)
+		ch <- prometheus.MustNewConstMetric(e.nodeFdUsed, prometheus.GaugeValue, n.FdUsed, n.Name)
+		ch <- prometheus.MustNewConstMetric(e.nodeSocketsUsed, prometheus.GaugeValue, n.SocketsUsed, n.Name)
+		ch <- prometheus.MustNewConstMetric(e.nodeErlangProcessesUsed, prometheus.GaugeValue, n.ProcUsed, n.Name)
+		ch <- prometheus.MustNewConstMetric(e.nodePartitions, prometheus.GaugeValue, float64(len(n.Partitions)), n.Name)
+	}
+
+	links, err := node.getFederationLinks()
+	if err != nil {
+		log.Errorf("scraping federation links for node %s: %v", node.Name, err)
+		success = false
+	}
+	for _, link := range links {
+		ch <- prometheus.MustNewConstMetric(e.federationLinkState, prometheus.GaugeValue, runningValue(link.Status), link.Vhost, link.Upstream, link.Queue, link.Status)
+	}
+
+	shovels, err := node.getShovels()
+	if err != nil {
+		log.Errorf("scraping shovels for node %s: %v", node.Name, err)
+		success = false
+	}
+	for _, shovel := range shovels {
+		ch <- prometheus.MustNewConstMetric(e.shovelState, prometheus.GaugeValue, runningValue(shovel.State), shovel.Vhost, shovel.Name, shovel.State)
+	}
+
+	policies, err := node.getPolicies()
+	if err != nil {
+		log.Errorf("scraping policies for node %s: %v", node.Name, err)
+		success = false
+	}
+	policiesPerVhost := make(map[string]float64)
+	for _, policy := range policies {
+		policiesPerVhost[policy.Vhost]++
+	}
+	for vhost, count := range policiesPerVhost {
+		ch <- prometheus.MustNewConstMetric(e.policyApplied, prometheus.GaugeValue, count, vhost)
+	}
+
+	return success
+}
+
+// runningValue reports the federation/shovel state as a 1/0 gauge value, so
+// an alert can fire simply on the metric going to 0.
+func runningValue(state string) float64 {
+	if state == "running" {
+		return 1
+	}
+	return 0
+}
+
+// boolValue renders a bool as a 1/0 gauge value.
+func boolValue(b bool) float64 {
+	if b {
+		return 1
+	}
+	return 0
+}
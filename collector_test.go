@@ -0,0 +1,248 @@
+package main
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/testutil"
+)
+
+// newTestBroker starts a fake management API exposing just enough of
+// /api/overview for the overview metrics to be emitted; every other
+// endpoint 404s, so the node's scrape as a whole is reported as failed.
+func newTestBroker(t *testing.T) *httptest.Server {
+	t.Helper()
+	mux := http.NewServeMux()
+	mux.HandleFunc("/api/overview", func(w http.ResponseWriter, r *http.Request) {
+		json.NewEncoder(w).Encode(map[string]interface{}{
+			"node": "rabbit@test",
+			"object_totals": map[string]interface{}{
+				"channels":    1.0,
+				"connections": 1.0,
+				"consumers":   1.0,
+				"queues":      1.0,
+				"exchanges":   1.0,
+			},
+			"queue_totals": map[string]interface{}{
+				"messages":                0.0,
+				"messages_unacknowledged": 0.0,
+			},
+			"message_stats": map[string]interface{}{
+				"publish": 42.0,
+			},
+		})
+	})
+	mux.HandleFunc("/", func(w http.ResponseWriter, r *http.Request) {
+		http.NotFound(w, r)
+	})
+	return httptest.NewServer(mux)
+}
+
+// TestMessagesPublishedIsACounter guards against the exposed type metadata
+// for rabbitmq_messages_published silently flipping between counter and
+// gauge, which would look like a counter reset to rate()/increase().
+func TestMessagesPublishedIsACounter(t *testing.T) {
+	server := newTestBroker(t)
+	defer server.Close()
+
+	node := Node{Name: "test", Url: server.URL, Uname: "guest", Password: "guest"}
+	if err := node.prepare(); err != nil {
+		t.Fatalf("prepare: %v", err)
+	}
+
+	config := &Config{Nodes: &[]Node{node}}
+	exporter, err := NewRabbitExporter(config)
+	if err != nil {
+		t.Fatalf("NewRabbitExporter: %v", err)
+	}
+
+	registry := prometheus.NewPedanticRegistry()
+	registry.MustRegister(exporter)
+
+	metricFamilies, err := registry.Gather()
+	if err != nil {
+		t.Fatalf("Gather: %v", err)
+	}
+
+	var found bool
+	for _, mf := range metricFamilies {
+		if mf.GetName() != "rabbitmq_messages_published" {
+			continue
+		}
+		found = true
+		if got := mf.GetType().String(); got != "COUNTER" {
+			t.Errorf("rabbitmq_messages_published type = %s, want COUNTER", got)
+		}
+	}
+	if !found {
+		t.Fatal("rabbitmq_messages_published was not exposed")
+	}
+}
+
+// TestPolicyAppliedIsAGauge guards against rabbitmq_policy_applied being
+// exposed as a counter: it's a point-in-time count of policies in a vhost,
+// computed fresh on every scrape, and can legitimately decrease.
+func TestPolicyAppliedIsAGauge(t *testing.T) {
+	mux := http.NewServeMux()
+	mux.HandleFunc("/api/overview", func(w http.ResponseWriter, r *http.Request) {
+		json.NewEncoder(w).Encode(map[string]interface{}{
+			"node":          "rabbit@test",
+			"object_totals": map[string]interface{}{},
+			"queue_totals":  map[string]interface{}{},
+			"message_stats": map[string]interface{}{},
+		})
+	})
+	mux.HandleFunc("/api/policies", func(w http.ResponseWriter, r *http.Request) {
+		json.NewEncoder(w).Encode([]map[string]string{{"vhost": "/", "name": "ha"}})
+	})
+	mux.HandleFunc("/", func(w http.ResponseWriter, r *http.Request) {
+		http.NotFound(w, r)
+	})
+	server := httptest.NewServer(mux)
+	defer server.Close()
+
+	node := Node{Name: "test", Url: server.URL, Uname: "guest", Password: "guest"}
+	if err := node.prepare(); err != nil {
+		t.Fatalf("prepare: %v", err)
+	}
+
+	config := &Config{Nodes: &[]Node{node}}
+	exporter, err := NewRabbitExporter(config)
+	if err != nil {
+		t.Fatalf("NewRabbitExporter: %v", err)
+	}
+
+	registry := prometheus.NewPedanticRegistry()
+	registry.MustRegister(exporter)
+
+	metricFamilies, err := registry.Gather()
+	if err != nil {
+		t.Fatalf("Gather: %v", err)
+	}
+
+	var found bool
+	for _, mf := range metricFamilies {
+		if mf.GetName() != "rabbitmq_policy_applied" {
+			continue
+		}
+		found = true
+		if got := mf.GetType().String(); got != "GAUGE" {
+			t.Errorf("rabbitmq_policy_applied type = %s, want GAUGE", got)
+		}
+	}
+	if !found {
+		t.Fatal("rabbitmq_policy_applied was not exposed")
+	}
+}
+
+func TestRunningValue(t *testing.T) {
+	if got, want := runningValue("running"), 1.0; got != want {
+		t.Errorf("runningValue(\"running\") = %v, want %v", got, want)
+	}
+	if got, want := runningValue("starting"), 0.0; got != want {
+		t.Errorf("runningValue(\"starting\") = %v, want %v", got, want)
+	}
+}
+
+func TestBoolValue(t *testing.T) {
+	if got, want := boolValue(true), 1.0; got != want {
+		t.Errorf("boolValue(true) = %v, want %v", got, want)
+	}
+	if got, want := boolValue(false), 0.0; got != want {
+		t.Errorf("boolValue(false) = %v, want %v", got, want)
+	}
+}
+
+// TestQueueMetricsUseOverviewNodename guards against per-queue metrics
+// drifting back to node.Name (the operator-assigned config
+// identifier) while overview-derived metrics use nodename (the Erlang node
+// name from /api/overview): a mismatch would break any PromQL join across
+// metric families on the "node" label.
+func TestQueueMetricsUseOverviewNodename(t *testing.T) {
+	mux := http.NewServeMux()
+	mux.HandleFunc("/api/overview", func(w http.ResponseWriter, r *http.Request) {
+		json.NewEncoder(w).Encode(map[string]interface{}{
+			"node":          "rabbit@ip-10-0-1-5",
+			"object_totals": map[string]interface{}{},
+			"queue_totals":  map[string]interface{}{},
+			"message_stats": map[string]interface{}{},
+		})
+	})
+	mux.HandleFunc("/api/queues", func(w http.ResponseWriter, r *http.Request) {
+		json.NewEncoder(w).Encode([]QueueInfo{{Name: "orders", Vhost: "/", MessagesReady: 5}})
+	})
+	mux.HandleFunc("/", func(w http.ResponseWriter, r *http.Request) {
+		http.NotFound(w, r)
+	})
+	server := httptest.NewServer(mux)
+	defer server.Close()
+
+	node := Node{Name: "prod-rabbit", Url: server.URL, Uname: "guest", Password: "guest"}
+	if err := node.prepare(); err != nil {
+		t.Fatalf("prepare: %v", err)
+	}
+
+	config := &Config{Nodes: &[]Node{node}}
+	exporter, err := NewRabbitExporter(config)
+	if err != nil {
+		t.Fatalf("NewRabbitExporter: %v", err)
+	}
+
+	registry := prometheus.NewPedanticRegistry()
+	registry.MustRegister(exporter)
+
+	if err := testutil.GatherAndCompare(registry, strings.NewReader(
+		"# HELP rabbitmq_queue_messages_ready Number of messages ready to be delivered to consumers.\n"+
+			"# TYPE rabbitmq_queue_messages_ready gauge\n"+
+			"rabbitmq_queue_messages_ready{node=\"rabbit@ip-10-0-1-5\",queue=\"orders\",vhost=\"/\"} 5\n",
+	), "rabbitmq_queue_messages_ready"); err != nil {
+		t.Error(err)
+	}
+}
+
+// TestVhostUpExposed ensures /api/vhosts is actually scraped into a metric.
+func TestVhostUpExposed(t *testing.T) {
+	mux := http.NewServeMux()
+	mux.HandleFunc("/api/overview", func(w http.ResponseWriter, r *http.Request) {
+		json.NewEncoder(w).Encode(map[string]interface{}{
+			"node":          "rabbit@test",
+			"object_totals": map[string]interface{}{},
+			"queue_totals":  map[string]interface{}{},
+			"message_stats": map[string]interface{}{},
+		})
+	})
+	mux.HandleFunc("/api/vhosts", func(w http.ResponseWriter, r *http.Request) {
+		json.NewEncoder(w).Encode([]map[string]string{{"name": "/"}})
+	})
+	mux.HandleFunc("/", func(w http.ResponseWriter, r *http.Request) {
+		http.NotFound(w, r)
+	})
+	server := httptest.NewServer(mux)
+	defer server.Close()
+
+	node := Node{Name: "test", Url: server.URL, Uname: "guest", Password: "guest"}
+	if err := node.prepare(); err != nil {
+		t.Fatalf("prepare: %v", err)
+	}
+
+	config := &Config{Nodes: &[]Node{node}}
+	exporter, err := NewRabbitExporter(config)
+	if err != nil {
+		t.Fatalf("NewRabbitExporter: %v", err)
+	}
+
+	registry := prometheus.NewPedanticRegistry()
+	registry.MustRegister(exporter)
+
+	if err := testutil.GatherAndCompare(registry, strings.NewReader(
+		"# HELP rabbitmq_vhost_up Whether a vhost is reported by the management API (1) or not (0).\n"+
+			"# TYPE rabbitmq_vhost_up gauge\n"+
+			"rabbitmq_vhost_up{node=\"rabbit@test\",vhost=\"/\"} 1\n",
+	), "rabbitmq_vhost_up"); err != nil {
+		t.Error(err)
+	}
+}
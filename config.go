@@ -0,0 +1,211 @@
+package main
+
+import (
+	"crypto/tls"
+	"crypto/x509"
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"os"
+	"regexp"
+	"strings"
+	"time"
+)
+
+type Config struct {
+	Nodes *[]Node `json:"nodes"`
+	Port  string  `json:"port"`
+}
+
+type Node struct {
+	Name            string `json:"name"`
+	Url             string `json:"url"`
+	Uname           string `json:"uname"`
+	Password        string `json:"password"`
+	QueueInclude    string `json:"queue_include,omitempty"`
+	QueueExclude    string `json:"queue_exclude,omitempty"`
+	ExchangeInclude string `json:"exchange_include,omitempty"`
+	ExchangeExclude string `json:"exchange_exclude,omitempty"`
+
+	Timeout            string `json:"timeout,omitempty"`
+	InsecureSkipVerify bool   `json:"insecure_skip_verify,omitempty"`
+	CAFile             string `json:"ca_file,omitempty"`
+	CertFile           string `json:"cert_file,omitempty"`
+	KeyFile            string `json:"key_file,omitempty"`
+
+	queueIncludeRe    *regexp.Regexp
+	queueExcludeRe    *regexp.Regexp
+	exchangeIncludeRe *regexp.Regexp
+	exchangeExcludeRe *regexp.Regexp
+
+	client  *http.Client
+	timeout time.Duration
+}
+
+// defaultTimeout is used when a node does not set its own timeout.
+const defaultTimeout = 10 * time.Second
+
+// prepare compiles the node's include/exclude filters and builds its cached
+// HTTP client once, so Collect doesn't redo either of those on every scrape.
+func (n *Node) prepare() error {
+	var err error
+	if n.queueIncludeRe, err = compileOptionalRegexp(n.QueueInclude); err != nil {
+		return err
+	}
+	if n.queueExcludeRe, err = compileOptionalRegexp(n.QueueExclude); err != nil {
+		return err
+	}
+	if n.exchangeIncludeRe, err = compileOptionalRegexp(n.ExchangeInclude); err != nil {
+		return err
+	}
+	if n.exchangeExcludeRe, err = compileOptionalRegexp(n.ExchangeExclude); err != nil {
+		return err
+	}
+	return n.buildClient()
+}
+
+// buildClient constructs the cached *http.Client used for every scrape of
+// this node, applying its timeout and TLS settings.
+func (n *Node) buildClient() error {
+	n.timeout = defaultTimeout
+	if len(n.Timeout) > 0 {
+		dt, err := time.ParseDuration(n.Timeout)
+		if err != nil {
+			return err
+		}
+		n.timeout = dt
+	}
+
+	tlsConfig := &tls.Config{InsecureSkipVerify: n.InsecureSkipVerify}
+
+	if len(n.CAFile) > 0 {
+		ca, err := ioutil.ReadFile(n.CAFile)
+		if err != nil {
+			return err
+		}
+		pool := x509.NewCertPool()
+		if !pool.AppendCertsFromPEM(ca) {
+			return fmt.Errorf("no certificates found in ca_file %q", n.CAFile)
+		}
+		tlsConfig.RootCAs = pool
+	}
+
+	if len(n.CertFile) > 0 || len(n.KeyFile) > 0 {
+		cert, err := tls.LoadX509KeyPair(n.CertFile, n.KeyFile)
+		if err != nil {
+			return err
+		}
+		tlsConfig.Certificates = []tls.Certificate{cert}
+	}
+
+	n.client = &http.Client{
+		Timeout:   n.timeout,
+		Transport: &http.Transport{TLSClientConfig: tlsConfig},
+	}
+	return nil
+}
+
+func compileOptionalRegexp(pattern string) (*regexp.Regexp, error) {
+	if len(pattern) == 0 {
+		return nil, nil
+	}
+	return regexp.Compile(pattern)
+}
+
+// queueAllowed reports whether a queue name passes the node's include/exclude filters.
+func (n *Node) queueAllowed(name string) bool {
+	if n.queueIncludeRe != nil && !n.queueIncludeRe.MatchString(name) {
+		return false
+	}
+	if n.queueExcludeRe != nil && n.queueExcludeRe.MatchString(name) {
+		return false
+	}
+	return true
+}
+
+// exchangeAllowed reports whether an exchange name passes the node's include/exclude filters.
+func (n *Node) exchangeAllowed(name string) bool {
+	if n.exchangeIncludeRe != nil && !n.exchangeIncludeRe.MatchString(name) {
+		return false
+	}
+	if n.exchangeExcludeRe != nil && n.exchangeExcludeRe.MatchString(name) {
+		return false
+	}
+	return true
+}
+
+func newConfig(path string) (*Config, error) {
+	var config Config
+
+	file, err := ioutil.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+	if err := json.Unmarshal(file, &config); err != nil {
+		return nil, err
+	}
+	return &config, nil
+}
+
+// buildConfig assembles the exporter's configuration, either from a JSON
+// config file (configPath) or, when no file is given, from the -rabbit.*
+// flags/env vars describing a single node. This lets the exporter run in
+// containers with no mounted config file.
+//
+// There is no req_interval/scrape-interval setting here: scraping happens
+// synchronously on each hit of the metrics endpoint, so scrape cadence is
+// governed entirely by Prometheus's own scrape_interval for this job.
+func buildConfig(configPath, rabbitURL, rabbitUser, rabbitPasswordFile string) (*Config, error) {
+	if len(configPath) > 0 {
+		config, err := newConfig(configPath)
+		if err != nil {
+			return nil, fmt.Errorf("loading config file %q: %v", configPath, err)
+		}
+		if config.Nodes == nil || len(*config.Nodes) == 0 {
+			return nil, fmt.Errorf("config file %q defines no nodes", configPath)
+		}
+		return config, nil
+	}
+
+	if len(rabbitURL) == 0 {
+		return nil, fmt.Errorf("no configuration provided: set -config.path, or -rabbit.url (RABBIT_URL)")
+	}
+
+	password, err := resolvePassword(rabbitPasswordFile)
+	if err != nil {
+		return nil, err
+	}
+
+	node := Node{
+		Name:     rabbitURL,
+		Url:      rabbitURL,
+		Uname:    rabbitUser,
+		Password: password,
+	}
+	return &Config{Nodes: &[]Node{node}}, nil
+}
+
+// resolvePassword reads the management API password from a file when one is
+// configured, falling back to the RABBIT_PASSWORD env var, so it never has
+// to be written out in plaintext JSON.
+func resolvePassword(passwordFile string) (string, error) {
+	if len(passwordFile) > 0 {
+		b, err := ioutil.ReadFile(passwordFile)
+		if err != nil {
+			return "", fmt.Errorf("reading rabbit.password-file: %v", err)
+		}
+		return strings.TrimSpace(string(b)), nil
+	}
+	return os.Getenv("RABBIT_PASSWORD"), nil
+}
+
+// envOrDefault returns the named environment variable's value, or def if it
+// is unset or empty. Used to give flags an env-var-backed default so the
+// exporter can be configured entirely through the environment in containers.
+func envOrDefault(key, def string) string {
+	if v := os.Getenv(key); len(v) > 0 {
+		return v
+	}
+	return def
+}
@@ -0,0 +1,95 @@
+package main
+
+import (
+	"io/ioutil"
+	"testing"
+)
+
+func TestNodeQueueAllowed(t *testing.T) {
+	n := Node{QueueInclude: "^app\\.", QueueExclude: "\\.retry$"}
+	if err := n.prepare(); err != nil {
+		t.Fatalf("prepare: %v", err)
+	}
+
+	cases := []struct {
+		name string
+		want bool
+	}{
+		{"app.orders", true},
+		{"app.orders.retry", false},
+		{"other.queue", false},
+	}
+	for _, c := range cases {
+		if got := n.queueAllowed(c.name); got != c.want {
+			t.Errorf("queueAllowed(%q) = %v, want %v", c.name, got, c.want)
+		}
+	}
+}
+
+func TestNodeExchangeAllowed(t *testing.T) {
+	n := Node{}
+	if err := n.prepare(); err != nil {
+		t.Fatalf("prepare: %v", err)
+	}
+
+	// No filters configured: everything passes.
+	if !n.exchangeAllowed("anything") {
+		t.Error("exchangeAllowed(\"anything\") = false, want true with no filters configured")
+	}
+}
+
+func TestCompileOptionalRegexp(t *testing.T) {
+	if re, err := compileOptionalRegexp(""); err != nil || re != nil {
+		t.Errorf("compileOptionalRegexp(\"\") = (%v, %v), want (nil, nil)", re, err)
+	}
+	if _, err := compileOptionalRegexp("["); err == nil {
+		t.Error("compileOptionalRegexp(\"[\") did not return an error for invalid regexp")
+	}
+}
+
+func TestBuildConfigFromFlags(t *testing.T) {
+	config, err := buildConfig("", "http://rabbit.example.com", "guest", "")
+	if err != nil {
+		t.Fatalf("buildConfig: %v", err)
+	}
+	if config.Nodes == nil || len(*config.Nodes) != 1 {
+		t.Fatalf("buildConfig produced %d nodes, want 1", len(*config.Nodes))
+	}
+	if got := (*config.Nodes)[0].Url; got != "http://rabbit.example.com" {
+		t.Errorf("node Url = %q, want %q", got, "http://rabbit.example.com")
+	}
+}
+
+func TestBuildConfigRequiresURLOrConfigPath(t *testing.T) {
+	if _, err := buildConfig("", "", "guest", ""); err == nil {
+		t.Error("buildConfig with no config path and no rabbit URL did not return an error")
+	}
+}
+
+func TestResolvePasswordFromFile(t *testing.T) {
+	dir := t.TempDir()
+	path := dir + "/password"
+	if err := ioutil.WriteFile(path, []byte("s3cret\n"), 0600); err != nil {
+		t.Fatalf("WriteFile: %v", err)
+	}
+
+	password, err := resolvePassword(path)
+	if err != nil {
+		t.Fatalf("resolvePassword: %v", err)
+	}
+	if password != "s3cret" {
+		t.Errorf("resolvePassword = %q, want %q", password, "s3cret")
+	}
+}
+
+func TestResolvePasswordFromEnv(t *testing.T) {
+	t.Setenv("RABBIT_PASSWORD", "envsecret")
+
+	password, err := resolvePassword("")
+	if err != nil {
+		t.Fatalf("resolvePassword: %v", err)
+	}
+	if password != "envsecret" {
+		t.Errorf("resolvePassword = %q, want %q", password, "envsecret")
+	}
+}
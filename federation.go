@@ -0,0 +1,62 @@
+package main
+
+// FederationLinkInfo models the subset of the /api/federation-links
+// response we care about.
+type FederationLinkInfo struct {
+	Vhost    string `json:"vhost"`
+	Upstream string `json:"upstream"`
+	Queue    string `json:"queue"`
+	Status   string `json:"status"`
+}
+
+// ShovelInfo models the subset of the /api/shovels response we care about.
+type ShovelInfo struct {
+	Vhost string `json:"vhost"`
+	Name  string `json:"name"`
+	State string `json:"state"`
+}
+
+// PolicyInfo models the subset of the /api/policies response we care about.
+type PolicyInfo struct {
+	Vhost string `json:"vhost"`
+	Name  string `json:"name"`
+}
+
+// getFederationLinks returns the broker's federation links, or an empty
+// slice with no error if the federation plugin isn't enabled.
+func (n *Node) getFederationLinks() ([]FederationLinkInfo, error) {
+	var links []FederationLinkInfo
+	if err := n.getJSON("/api/federation-links", &links); err != nil {
+		if err == errNotFound {
+			return nil, nil
+		}
+		return nil, err
+	}
+	return links, nil
+}
+
+// getShovels returns the broker's shovels, or an empty slice with no error
+// if the shovel plugin isn't enabled.
+func (n *Node) getShovels() ([]ShovelInfo, error) {
+	var shovels []ShovelInfo
+	if err := n.getJSON("/api/shovels", &shovels); err != nil {
+		if err == errNotFound {
+			return nil, nil
+		}
+		return nil, err
+	}
+	return shovels, nil
+}
+
+// getPolicies returns the broker's policies, or an empty slice with no
+// error if the broker reports none.
+func (n *Node) getPolicies() ([]PolicyInfo, error) {
+	var policies []PolicyInfo
+	if err := n.getJSON("/api/policies", &policies); err != nil {
+		if err == errNotFound {
+			return nil, nil
+		}
+		return nil, err
+	}
+	return policies, nil
+}